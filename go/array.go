@@ -0,0 +1,316 @@
+package jtran
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// init registers the stock array-processing keywords alongside the
+// scalar ones defined in jtran.go.
+func init() {
+	for name, handler := range arrayKeywordHandlers {
+		stockKeywordHandlers[name] = handler
+	}
+}
+
+// arrayKeywordHandlers holds the stock keywords that operate on
+// []interface{} values, as produced by ResolveField for a JSON array
+// field. where, uniq, groupby and map expect their array elements to be
+// objects (map[string]interface{}) so that "field" arguments can be
+// resolved with ResolveField, including nested paths like "profile->age".
+// sort orders a non-object element as if its field were nil, and first and
+// last accept elements of any type.
+var arrayKeywordHandlers = map[string]KeywordHandler{
+	"where": func(value interface{}, ctx *Context, args []Value) (interface{}, error) {
+		arrayVal, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("where keyword expects an array of values")
+		}
+		if len(args) != 3 {
+			return nil, fmt.Errorf("where keyword: expected 'field,op,value' arguments")
+		}
+		field, err := argString(args, 0, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("where keyword: %v", err)
+		}
+		op, err := argString(args, 1, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("where keyword: %v", err)
+		}
+		target, err := argString(args, 2, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("where keyword: %v", err)
+		}
+
+		results := []interface{}{}
+		for _, item := range arrayVal {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("where keyword: array item is not an object")
+			}
+			matched, err := compareWhere(ResolveField(field, itemMap), op, target)
+			if err != nil {
+				return nil, fmt.Errorf("where keyword: %v", err)
+			}
+			if matched {
+				results = append(results, item)
+			}
+		}
+		return results, nil
+	},
+	"sort": func(value interface{}, ctx *Context, args []Value) (interface{}, error) {
+		arrayVal, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("sort keyword expects an array of values")
+		}
+		if len(args) < 1 {
+			return nil, fmt.Errorf("sort keyword: expected a field argument")
+		}
+		field, err := argString(args, 0, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("sort keyword: %v", err)
+		}
+		desc := false
+		if len(args) > 1 {
+			dir, err := argString(args, 1, ctx)
+			if err != nil {
+				return nil, fmt.Errorf("sort keyword: %v", err)
+			}
+			desc = strings.EqualFold(dir, "desc")
+		}
+
+		sorted := append([]interface{}{}, arrayVal...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			cmp := compareReflect(resolveSortField(field, sorted[i]), resolveSortField(field, sorted[j]))
+			if desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		})
+		return sorted, nil
+	},
+	"first": func(value interface{}, ctx *Context, args []Value) (interface{}, error) {
+		arrayVal, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("first keyword expects an array of values")
+		}
+		n, err := argInt(args, 0, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("first keyword: %v", err)
+		}
+		if n < 0 {
+			n = 0
+		}
+		if n > len(arrayVal) {
+			n = len(arrayVal)
+		}
+		return arrayVal[:n], nil
+	},
+	"last": func(value interface{}, ctx *Context, args []Value) (interface{}, error) {
+		arrayVal, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("last keyword expects an array of values")
+		}
+		n, err := argInt(args, 0, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("last keyword: %v", err)
+		}
+		if n < 0 {
+			n = 0
+		}
+		if n > len(arrayVal) {
+			n = len(arrayVal)
+		}
+		return arrayVal[len(arrayVal)-n:], nil
+	},
+	"uniq": func(value interface{}, ctx *Context, args []Value) (interface{}, error) {
+		arrayVal, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("uniq keyword expects an array of values")
+		}
+		field, err := argString(args, 0, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("uniq keyword: %v", err)
+		}
+
+		seen := make(map[string]bool, len(arrayVal))
+		results := []interface{}{}
+		for _, item := range arrayVal {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("uniq keyword: array item is not an object")
+			}
+			key := fmt.Sprintf("%v", ResolveField(field, itemMap))
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			results = append(results, item)
+		}
+		return results, nil
+	},
+	"groupby": func(value interface{}, ctx *Context, args []Value) (interface{}, error) {
+		arrayVal, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("groupby keyword expects an array of values")
+		}
+		field, err := argString(args, 0, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("groupby keyword: %v", err)
+		}
+
+		groups := make(map[string][]interface{})
+		for _, item := range arrayVal {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("groupby keyword: array item is not an object")
+			}
+			key := fmt.Sprintf("%v", ResolveField(field, itemMap))
+			groups[key] = append(groups[key], item)
+		}
+		return groups, nil
+	},
+	"map": func(value interface{}, ctx *Context, args []Value) (interface{}, error) {
+		arrayVal, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("map keyword expects an array of values")
+		}
+		if len(args) != 1 {
+			return nil, fmt.Errorf("map keyword: expected a single \"${...}\" expression argument")
+		}
+
+		results := make([]interface{}, len(arrayVal))
+		for i, item := range arrayVal {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("map keyword: array item is not an object")
+			}
+			itemResult, err := args[0].Resolve(&Context{Data: itemMap})
+			if err != nil {
+				return nil, fmt.Errorf("map keyword: %v", err)
+			}
+			results[i] = itemResult
+		}
+		return results, nil
+	},
+}
+
+// resolveSortField resolves field against an array element, returning nil
+// if the element isn't an object.
+func resolveSortField(field string, item interface{}) interface{} {
+	itemMap, ok := item.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return ResolveField(field, itemMap)
+}
+
+// compareReflect orders two resolved field values: time.Time values
+// compare chronologically, numeric kinds compare by magnitude (via
+// reflection, so both Go and JSON-decoded number types work), and
+// everything else falls back to string comparison.
+func compareReflect(a, b interface{}) int {
+	if at, ok := a.(time.Time); ok {
+		if bt, ok := b.(time.Time); ok {
+			switch {
+			case at.Before(bt):
+				return -1
+			case at.After(bt):
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+
+	if af, aok := numericValue(reflect.ValueOf(a)); aok {
+		if bf, bok := numericValue(reflect.ValueOf(b)); bok {
+			switch {
+			case af < bf:
+				return -1
+			case af > bf:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+
+	return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
+
+func numericValue(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// compareWhere evaluates a single where() comparison between a resolved
+// field value and a literal target.
+func compareWhere(fieldValue interface{}, op, target string) (bool, error) {
+	switch op {
+	case "eq":
+		return fmt.Sprintf("%v", fieldValue) == target, nil
+	case "ne":
+		return fmt.Sprintf("%v", fieldValue) != target, nil
+	case "lt", "le", "gt", "ge":
+		fv, ok := toFloat(fieldValue)
+		tv, err := parseFloatStrict(target)
+		if !ok || err != nil {
+			return false, fmt.Errorf("non-numeric comparison for op %q", op)
+		}
+		switch op {
+		case "lt":
+			return fv < tv, nil
+		case "le":
+			return fv <= tv, nil
+		case "gt":
+			return fv > tv, nil
+		case "ge":
+			return fv >= tv, nil
+		}
+	case "in":
+		for _, part := range strings.Split(target, ",") {
+			if strings.TrimSpace(part) == fmt.Sprintf("%v", fieldValue) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "contains":
+		switch fv := fieldValue.(type) {
+		case string:
+			return strings.Contains(fv, target), nil
+		case []interface{}:
+			for _, v := range fv {
+				if fmt.Sprintf("%v", v) == target {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+		return false, nil
+	case "matches":
+		re, err := regexp.Compile(target)
+		if err != nil {
+			return false, fmt.Errorf("invalid regex: %v", err)
+		}
+		return re.MatchString(fmt.Sprintf("%v", fieldValue)), nil
+	}
+	return false, fmt.Errorf("unknown operator %q", op)
+}
+
+func parseFloatStrict(s string) (float64, error) {
+	var f float64
+	_, err := fmt.Sscanf(s, "%f", &f)
+	return f, err
+}
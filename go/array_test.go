@@ -0,0 +1,205 @@
+package jtran
+
+import (
+	"testing"
+	"time"
+)
+
+func callArrayKeyword(t *testing.T, name string, value interface{}, args ...string) (interface{}, error) {
+	t.Helper()
+	handler, ok := stockKeywordHandlers[name]
+	if !ok {
+		t.Fatalf("no stock handler registered for %q", name)
+	}
+	vals := make([]Value, len(args))
+	for i, a := range args {
+		vals[i] = Value{text: a}
+	}
+	return handler(value, &Context{Data: map[string]interface{}{}}, vals)
+}
+
+func usersFixture() []interface{} {
+	return []interface{}{
+		map[string]interface{}{"name": "Amy", "age": 31.0, "tags": []interface{}{"eng"}},
+		map[string]interface{}{"name": "Bo", "age": 20.0, "tags": []interface{}{"sales"}},
+		map[string]interface{}{"name": "Cole", "age": 45.0, "tags": []interface{}{"eng", "lead"}},
+	}
+}
+
+func TestWhereOperators(t *testing.T) {
+	cases := []struct {
+		name  string
+		field string
+		op    string
+		value string
+		want  []string
+	}{
+		{"eq", "name", "eq", "Bo", []string{"Bo"}},
+		{"ne", "name", "ne", "Bo", []string{"Amy", "Cole"}},
+		{"lt", "age", "lt", "31", []string{"Bo"}},
+		{"le", "age", "le", "31", []string{"Amy", "Bo"}},
+		{"gt", "age", "gt", "31", []string{"Cole"}},
+		{"ge", "age", "ge", "31", []string{"Amy", "Cole"}},
+		{"in", "name", "in", "Bo,Cole", []string{"Bo", "Cole"}},
+		{"contains", "tags", "contains", "lead", []string{"Cole"}},
+		{"matches", "name", "matches", "^C", []string{"Cole"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result, err := callArrayKeyword(t, "where", usersFixture(), c.field, c.op, c.value)
+			if err != nil {
+				t.Fatalf("where(%s,%s,%s): %v", c.field, c.op, c.value, err)
+			}
+			matched := result.([]interface{})
+			if len(matched) != len(c.want) {
+				t.Fatalf("where(%s,%s,%s) = %v, want %v", c.field, c.op, c.value, matched, c.want)
+			}
+			for i, item := range matched {
+				if item.(map[string]interface{})["name"] != c.want[i] {
+					t.Fatalf("where(%s,%s,%s) = %v, want %v", c.field, c.op, c.value, matched, c.want)
+				}
+			}
+		})
+	}
+
+	t.Run("unknown operator", func(t *testing.T) {
+		if _, err := callArrayKeyword(t, "where", usersFixture(), "age", "bogus", "0"); err == nil {
+			t.Fatal("where with an unknown operator: want error, got nil")
+		}
+	})
+
+	t.Run("non-array value", func(t *testing.T) {
+		if _, err := callArrayKeyword(t, "where", "not-an-array", "age", "eq", "0"); err == nil {
+			t.Fatal("where on a non-array value: want error, got nil")
+		}
+	})
+}
+
+func TestSortAscDesc(t *testing.T) {
+	asc, err := callArrayKeyword(t, "sort", usersFixture(), "age")
+	if err != nil {
+		t.Fatalf("sort(age): %v", err)
+	}
+	wantAsc := []string{"Bo", "Amy", "Cole"}
+	for i, item := range asc.([]interface{}) {
+		if item.(map[string]interface{})["name"] != wantAsc[i] {
+			t.Fatalf("sort(age) = %v, want order %v", asc, wantAsc)
+		}
+	}
+
+	desc, err := callArrayKeyword(t, "sort", usersFixture(), "name", "desc")
+	if err != nil {
+		t.Fatalf("sort(name,desc): %v", err)
+	}
+	wantDesc := []string{"Cole", "Bo", "Amy"}
+	for i, item := range desc.([]interface{}) {
+		if item.(map[string]interface{})["name"] != wantDesc[i] {
+			t.Fatalf("sort(name,desc) = %v, want order %v", desc, wantDesc)
+		}
+	}
+
+	if _, err := callArrayKeyword(t, "sort", "not-an-array", "age"); err == nil {
+		t.Fatal("sort on a non-array value: want error, got nil")
+	}
+}
+
+func TestCompareReflectTimeFallback(t *testing.T) {
+	earlier := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	if cmp := compareReflect(earlier, later); cmp >= 0 {
+		t.Fatalf("compareReflect(earlier, later) = %d, want < 0", cmp)
+	}
+	if cmp := compareReflect(later, earlier); cmp <= 0 {
+		t.Fatalf("compareReflect(later, earlier) = %d, want > 0", cmp)
+	}
+	if cmp := compareReflect("b", "a"); cmp <= 0 {
+		t.Fatalf(`compareReflect("b", "a") = %d, want > 0 (string fallback)`, cmp)
+	}
+}
+
+func TestFirstAndLast(t *testing.T) {
+	first, err := callArrayKeyword(t, "first", usersFixture(), "2")
+	if err != nil {
+		t.Fatalf("first(2): %v", err)
+	}
+	if got := len(first.([]interface{})); got != 2 {
+		t.Fatalf("first(2) returned %d items, want 2", got)
+	}
+
+	last, err := callArrayKeyword(t, "last", usersFixture(), "2")
+	if err != nil {
+		t.Fatalf("last(2): %v", err)
+	}
+	if got := len(last.([]interface{})); got != 2 {
+		t.Fatalf("last(2) returned %d items, want 2", got)
+	}
+
+	if _, err := callArrayKeyword(t, "first", "not-an-array", "2"); err == nil {
+		t.Fatal("first on a non-array value: want error, got nil")
+	}
+	if _, err := callArrayKeyword(t, "last", "not-an-array", "2"); err == nil {
+		t.Fatal("last on a non-array value: want error, got nil")
+	}
+}
+
+func TestUniq(t *testing.T) {
+	people := []interface{}{
+		map[string]interface{}{"name": "Amy", "team": "eng"},
+		map[string]interface{}{"name": "Bo", "team": "eng"},
+		map[string]interface{}{"name": "Cole", "team": "sales"},
+	}
+	result, err := callArrayKeyword(t, "uniq", people, "team")
+	if err != nil {
+		t.Fatalf("uniq(team): %v", err)
+	}
+	if got := len(result.([]interface{})); got != 2 {
+		t.Fatalf("uniq(team) returned %d items, want 2", got)
+	}
+
+	if _, err := callArrayKeyword(t, "uniq", "not-an-array", "team"); err == nil {
+		t.Fatal("uniq on a non-array value: want error, got nil")
+	}
+}
+
+func TestGroupby(t *testing.T) {
+	result, err := callArrayKeyword(t, "groupby", usersFixture(), "tags")
+	if err != nil {
+		t.Fatalf("groupby(tags): %v", err)
+	}
+	groups, ok := result.(map[string][]interface{})
+	if !ok {
+		t.Fatalf("groupby(tags) returned %T, want map[string][]interface{}", result)
+	}
+	if len(groups) == 0 {
+		t.Fatal("groupby(tags) returned no groups")
+	}
+
+	if _, err := callArrayKeyword(t, "groupby", "not-an-array", "tags"); err == nil {
+		t.Fatal("groupby on a non-array value: want error, got nil")
+	}
+}
+
+func TestMap(t *testing.T) {
+	handler := stockKeywordHandlers["map"]
+	nested, err := ParseTemplate("${name|uppercase}")
+	if err != nil {
+		t.Fatalf("ParseTemplate: %v", err)
+	}
+	expr := nested.Parts[0].Expr
+	result, err := handler(usersFixture(), &Context{}, []Value{{text: expr.Field, expr: expr}})
+	if err != nil {
+		t.Fatalf("map(...): %v", err)
+	}
+	names := result.([]interface{})
+	if names[0] != "AMY" || names[1] != "BO" || names[2] != "COLE" {
+		t.Fatalf("map(...) = %v, want [AMY BO COLE]", names)
+	}
+
+	if _, err := callArrayKeyword(t, "map", "not-an-array", "${name}"); err == nil {
+		t.Fatal("map on a non-array value: want error, got nil")
+	}
+	if _, err := callArrayKeyword(t, "map", usersFixture()); err == nil {
+		t.Fatal("map with no argument: want error, got nil")
+	}
+}
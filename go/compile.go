@@ -0,0 +1,210 @@
+package jtran
+
+import (
+	"fmt"
+	"strings"
+)
+
+// valueNode is a compiled schema value: a literal, a templated/piped
+// string, an object, or an array. Eval never re-parses the schema.
+type valueNode interface {
+	Eval(ctx *Context) (interface{}, error)
+}
+
+// literalNode passes a non-string (or keyword-free string) schema value
+// through unchanged.
+type literalNode struct{ value interface{} }
+
+func (n literalNode) Eval(ctx *Context) (interface{}, error) {
+	return n.value, nil
+}
+
+// templateValueNode expands a "${field|keyword(args)}" template embedded
+// in a larger literal string into its string result.
+type templateValueNode struct{ tmpl *Template }
+
+func (n templateValueNode) Eval(ctx *Context) (interface{}, error) {
+	return EvalTemplate(n.tmpl, ctx), nil
+}
+
+// pipelineValueNode is a bare "field|keyword(args)|..." string (no "${}"
+// wrapper): it returns the typed result of the pipeline rather than a
+// string.
+//
+// Note this is a deliberate behavior change from the pre-lexer baseline,
+// which treated the text before "|" as a literal rather than resolving it
+// with ResolveField. Resolving it is what lets array.go's keywords (where,
+// sort, map, ...) operate on an actual field's array value via a bare
+// "users|where(...)" pipeline instead of only working inside "${...}".
+type pipelineValueNode struct{ expr *Expr }
+
+func (n pipelineValueNode) Eval(ctx *Context) (interface{}, error) {
+	return evalExpr(n.expr, ctx)
+}
+
+// objectField pairs a compiled key (keys can themselves be templated) with
+// its compiled value.
+type objectField struct {
+	key   valueNode
+	value valueNode
+}
+
+// objectNode is a compiled schema object; it also implements valueNode so
+// nested objects compile and evaluate uniformly.
+type objectNode struct{ fields []objectField }
+
+func (n objectNode) Eval(ctx *Context) (interface{}, error) {
+	transformed := make(map[string]interface{}, len(n.fields))
+	for _, field := range n.fields {
+		keyVal, err := field.key.Eval(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply keywords to key: %v", err)
+		}
+		strKey, ok := keyVal.(string)
+		if !ok {
+			return nil, fmt.Errorf("key '%v' did not resolve to a string", keyVal)
+		}
+
+		value, err := field.value.Eval(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply keywords to value of key '%s': %v", strKey, err)
+		}
+		transformed[strKey] = value
+	}
+	return transformed, nil
+}
+
+// arrayNode is a compiled schema array; each item was compiled according
+// to the same rules as a top-level schema value.
+type arrayNode struct{ items []valueNode }
+
+func (n arrayNode) Eval(ctx *Context) (interface{}, error) {
+	transformed := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		value, err := item.Eval(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply keywords to array item: %v", err)
+		}
+		transformed[i] = value
+	}
+	return transformed, nil
+}
+
+// CompiledSchema is a schema that has already been walked and parsed once,
+// so Transform never re-runs the template lexer or re-splits keyword
+// pipelines.
+type CompiledSchema struct {
+	root objectNode
+}
+
+// Compile walks schema once, producing a tree of typed nodes that Transform
+// can evaluate against many records without re-parsing the schema.
+func Compile(schema map[string]interface{}) (*CompiledSchema, error) {
+	root, err := compileObject(schema)
+	if err != nil {
+		return nil, err
+	}
+	return &CompiledSchema{root: root}, nil
+}
+
+// Transform evaluates the compiled schema against data.
+func (c *CompiledSchema) Transform(data map[string]interface{}) (map[string]interface{}, error) {
+	ctx := &Context{Data: data}
+	result, err := c.root.Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return result.(map[string]interface{}), nil
+}
+
+func compileObject(schema map[string]interface{}) (objectNode, error) {
+	fields := make([]objectField, 0, len(schema))
+	for key, value := range schema {
+		keyNode, err := compileString(key)
+		if err != nil {
+			return objectNode{}, fmt.Errorf("failed to compile key '%s': %v", key, err)
+		}
+
+		valueNode, err := compileValue(value)
+		if err != nil {
+			return objectNode{}, fmt.Errorf("failed to compile value of key '%s': %v", key, err)
+		}
+
+		fields = append(fields, objectField{key: keyNode, value: valueNode})
+	}
+	return objectNode{fields: fields}, nil
+}
+
+func compileValue(value interface{}) (valueNode, error) {
+	switch v := value.(type) {
+	case string:
+		return compileString(v)
+	case map[string]interface{}:
+		obj, err := compileObject(v)
+		if err != nil {
+			return nil, err
+		}
+		return obj, nil
+	case []interface{}:
+		items := make([]valueNode, len(v))
+		for i, item := range v {
+			itemNode, err := compileValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("array item %d: %v", i, err)
+			}
+			items[i] = itemNode
+		}
+		return arrayNode{items: items}, nil
+	default:
+		return literalNode{value: v}, nil
+	}
+}
+
+// compileString parses a schema string exactly once into either a literal,
+// a "${...}" template, or a bare keyword pipeline.
+//
+// A pipeline argument may itself embed a nested "${...}" expression (e.g.
+// "people|map(${name|uppercase})"), so a value can contain both "|" and
+// "${" and still be a pipeline rather than a template. Pipeline parsing is
+// tried first in that case; it only succeeds when the whole value is a
+// single "field|stage..." expression with no surrounding literal text, so
+// a genuine template like "Hello ${name}!" falls through correctly.
+func compileString(value string) (valueNode, error) {
+	hasPipe := strings.Contains(value, "|")
+	hasTemplate := strings.Contains(value, "${")
+
+	if hasPipe {
+		if expr, err := ParsePipeline(value); err == nil {
+			return pipelineValueNode{expr: expr}, nil
+		} else if !hasTemplate {
+			return nil, err
+		}
+	}
+
+	if hasTemplate {
+		tmpl, err := ParseTemplate(value)
+		if err != nil {
+			return nil, err
+		}
+		return templateValueNode{tmpl: tmpl}, nil
+	}
+
+	return literalNode{value: value}, nil
+}
+
+// TransformData applies schema to data. It compiles schema and executes the
+// result; for repeated use against many records, call Compile once and
+// reuse the returned CompiledSchema instead.
+//
+// This is a drop-in replacement for the original regex-based TransformData
+// for "${...}" template values and plain literals. A bare "field|keyword"
+// value (no "${}") is not quite backward compatible, though: "field" is now
+// resolved with ResolveField like every other field expression, rather than
+// passed to the first keyword as a literal string (see pipelineValueNode).
+func TransformData(schema map[string]interface{}, data map[string]interface{}) (map[string]interface{}, error) {
+	compiled, err := Compile(schema)
+	if err != nil {
+		return nil, err
+	}
+	return compiled.Transform(data)
+}
@@ -0,0 +1,257 @@
+package jtran
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// RegisterFunc registers fn as a keyword handler under name, modeled on
+// text/template's FuncMap: fn's first parameter receives the value being
+// piped through the stage, its remaining parameters receive the
+// keyword's call arguments coerced to each parameter's declared type,
+// and a final variadic parameter accepts any number of trailing
+// arguments. fn must return either a single result or a (result, error)
+// pair. RegisterFunc panics if fn's signature doesn't satisfy this, the
+// same way (*text/template.Template).Funcs panics on a bad entry.
+//
+// RegisterKeyword remains the low-level escape hatch for handlers that
+// need direct access to *Context or the unresolved Value arguments;
+// RegisterFunc is the easier path when a handler just needs typed Go
+// values.
+func RegisterFunc(name string, fn interface{}) {
+	keywordHandlers[name] = makeFuncHandler(name, fn)
+}
+
+// init dogfoods RegisterFunc for a few stock handlers whose signatures
+// are simple enough to express as plain Go functions.
+func init() {
+	stockKeywordHandlers["padleft"] = makeFuncHandler("padleft", padleftFunc)
+	stockKeywordHandlers["substring"] = makeFuncHandler("substring", substringFunc)
+	stockKeywordHandlers["truncate"] = makeFuncHandler("truncate", truncateFunc)
+}
+
+func padleftFunc(value, padChar string, length int) (string, error) {
+	if len(value) >= length {
+		return value, nil
+	}
+	return strings.Repeat(padChar, length-len(value)) + value, nil
+}
+
+func substringFunc(value string, bounds ...int) (string, error) {
+	start, end := 0, len(value)
+	switch len(bounds) {
+	case 0:
+	case 2:
+		start, end = bounds[0], bounds[1]
+	default:
+		return "", fmt.Errorf("expected 'start,end' arguments")
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end > len(value) {
+		end = len(value)
+	}
+	if start > end {
+		return "", fmt.Errorf("start index %d after end index %d", start, end)
+	}
+	return value[start:end], nil
+}
+
+func truncateFunc(value string, length int) (string, error) {
+	if length < 0 || length > len(value) {
+		return "", fmt.Errorf("length out of bounds")
+	}
+	return value[:length], nil
+}
+
+// makeFuncHandler adapts fn into a KeywordHandler, validating its
+// signature once at registration time so every subsequent call is just
+// argument coercion plus a reflect.Call.
+func makeFuncHandler(name string, fn interface{}) KeywordHandler {
+	fnVal := reflect.ValueOf(fn)
+	fnType := fnVal.Type()
+	if fnType.Kind() != reflect.Func {
+		panic(fmt.Sprintf("jtran: RegisterFunc(%q): fn must be a function", name))
+	}
+	if fnType.NumIn() == 0 {
+		panic(fmt.Sprintf("jtran: RegisterFunc(%q): fn must accept at least a value parameter", name))
+	}
+	switch fnType.NumOut() {
+	case 1:
+	case 2:
+		if !fnType.Out(1).Implements(errorType) {
+			panic(fmt.Sprintf("jtran: RegisterFunc(%q): second return value must be error", name))
+		}
+	default:
+		panic(fmt.Sprintf("jtran: RegisterFunc(%q): fn must return (result) or (result, error)", name))
+	}
+
+	variadic := fnType.IsVariadic()
+	fixedArgs := fnType.NumIn() - 1
+	if variadic {
+		fixedArgs--
+	}
+
+	return func(value interface{}, ctx *Context, args []Value) (interface{}, error) {
+		if !variadic && len(args) != fixedArgs {
+			return nil, fmt.Errorf("%s keyword: expected %d argument(s), got %d", name, fixedArgs, len(args))
+		}
+		if variadic && len(args) < fixedArgs {
+			return nil, fmt.Errorf("%s keyword: expected at least %d argument(s), got %d", name, fixedArgs, len(args))
+		}
+
+		in := make([]reflect.Value, 0, fnType.NumIn())
+		valueArg, err := coerceValueParam(value, fnType.In(0))
+		if err != nil {
+			return nil, fmt.Errorf("%s keyword: %v", name, err)
+		}
+		in = append(in, valueArg)
+
+		for i := 0; i < fixedArgs; i++ {
+			resolved, err := argResolved(args, i, ctx)
+			if err != nil {
+				return nil, fmt.Errorf("%s keyword: argument %d: %v", name, i+1, err)
+			}
+			coerced, err := coerceArg(resolved, fnType.In(i+1))
+			if err != nil {
+				return nil, fmt.Errorf("%s keyword: argument %d: %v", name, i+1, err)
+			}
+			in = append(in, coerced)
+		}
+
+		if variadic {
+			elemType := fnType.In(fnType.NumIn() - 1).Elem()
+			for i := fixedArgs; i < len(args); i++ {
+				resolved, err := argResolved(args, i, ctx)
+				if err != nil {
+					return nil, fmt.Errorf("%s keyword: argument %d: %v", name, i+1, err)
+				}
+				coerced, err := coerceArg(resolved, elemType)
+				if err != nil {
+					return nil, fmt.Errorf("%s keyword: argument %d: %v", name, i+1, err)
+				}
+				in = append(in, coerced)
+			}
+		}
+
+		out := fnVal.Call(in)
+		if len(out) == 2 {
+			if errVal, ok := out[1].Interface().(error); ok && errVal != nil {
+				return nil, fmt.Errorf("%s keyword: %v", name, errVal)
+			}
+		}
+		return out[0].Interface(), nil
+	}
+}
+
+// coerceValueParam adapts the piped-in stage value to fn's first
+// parameter. Unlike keyword arguments, the value isn't coerced across
+// types: it must already satisfy the parameter, the same way the
+// existing stock handlers fail fast on a bad `value.(string)` assertion.
+func coerceValueParam(value interface{}, target reflect.Type) (reflect.Value, error) {
+	if target.Kind() == reflect.Interface {
+		if value == nil {
+			return reflect.Zero(target), nil
+		}
+		v := reflect.ValueOf(value)
+		if !v.Type().AssignableTo(target) {
+			return reflect.Value{}, fmt.Errorf("value of type %T does not satisfy %s", value, target)
+		}
+		return v, nil
+	}
+	if value == nil {
+		return reflect.Value{}, fmt.Errorf("expected %s value but got nil", target)
+	}
+	v := reflect.ValueOf(value)
+	if !v.Type().AssignableTo(target) {
+		return reflect.Value{}, fmt.Errorf("expected %s value but got %T", target, value)
+	}
+	return v, nil
+}
+
+// coerceArg converts a resolved keyword argument (always a string for a
+// schema literal, or whatever type a nested "${...}" expression produced)
+// to fn's declared parameter type.
+func coerceArg(value interface{}, target reflect.Type) (reflect.Value, error) {
+	if target.Kind() == reflect.Interface {
+		if value == nil {
+			return reflect.Zero(target), nil
+		}
+		return reflect.ValueOf(value), nil
+	}
+	if value == nil {
+		return reflect.Zero(target), nil
+	}
+
+	if num, ok := value.(json.Number); ok {
+		switch target.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := num.Int64()
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("cannot convert %q to %s", num, target)
+			}
+			return reflect.ValueOf(n).Convert(target), nil
+		case reflect.Float32, reflect.Float64:
+			f, err := num.Float64()
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("cannot convert %q to %s", num, target)
+			}
+			return reflect.ValueOf(f).Convert(target), nil
+		case reflect.String:
+			return reflect.ValueOf(num.String()), nil
+		}
+	}
+
+	switch target.Kind() {
+	case reflect.String:
+		if s, ok := value.(string); ok {
+			return reflect.ValueOf(s), nil
+		}
+		return reflect.ValueOf(fmt.Sprintf("%v", value)), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch n := value.(type) {
+		case string:
+			parsed, err := strconv.ParseInt(n, 10, 64)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("cannot convert %q to %s", n, target)
+			}
+			return reflect.ValueOf(parsed).Convert(target), nil
+		case float64:
+			return reflect.ValueOf(int64(n)).Convert(target), nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch n := value.(type) {
+		case string:
+			parsed, err := strconv.ParseFloat(n, 64)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("cannot convert %q to %s", n, target)
+			}
+			return reflect.ValueOf(parsed).Convert(target), nil
+		case float64:
+			return reflect.ValueOf(n).Convert(target), nil
+		}
+	case reflect.Bool:
+		if s, ok := value.(string); ok {
+			parsed, err := strconv.ParseBool(s)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("cannot convert %q to bool", s)
+			}
+			return reflect.ValueOf(parsed), nil
+		}
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Type().AssignableTo(target) {
+		return v, nil
+	}
+	if v.Type().ConvertibleTo(target) {
+		return v.Convert(target), nil
+	}
+	return reflect.Value{}, fmt.Errorf("cannot convert %T to %s", value, target)
+}
@@ -0,0 +1,92 @@
+package jtran
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRegisterFuncVariadic(t *testing.T) {
+	RegisterFunc("joinWith", func(value string, sep string, rest ...string) (string, error) {
+		out := value
+		for _, r := range rest {
+			out += sep + r
+		}
+		return out, nil
+	})
+
+	schema := map[string]interface{}{"out": "${name|joinWith(-,a,b,c)}"}
+	result, err := TransformData(schema, map[string]interface{}{"name": "x"})
+	if err != nil {
+		t.Fatalf("TransformData: %v", err)
+	}
+	if result["out"] != "x-a-b-c" {
+		t.Fatalf("out = %v, want x-a-b-c", result["out"])
+	}
+}
+
+func TestRegisterFuncBadSignaturePanics(t *testing.T) {
+	cases := []struct {
+		name string
+		fn   interface{}
+	}{
+		{"not a function", "not a function"},
+		{"no parameters", func() string { return "" }},
+		{"too many return values", func(v string) (string, string, error) { return "", "", nil }},
+		{"second return not error", func(v string) (string, string) { return "", "" }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("RegisterFunc(%v): want panic, got none", c.fn)
+				}
+			}()
+			RegisterFunc("badFunc", c.fn)
+		})
+	}
+}
+
+func TestRegisterFuncReturnForms(t *testing.T) {
+	RegisterFunc("shoutOnlyValue", func(value string) string { return value + "!" })
+	RegisterFunc("shoutWithError", func(value string) (string, error) { return value + "?", nil })
+	RegisterFunc("alwaysErrors", func(value string) (string, error) {
+		return "", fmt.Errorf("always fails")
+	})
+
+	result, err := TransformData(map[string]interface{}{
+		"a": "${name|shoutOnlyValue}",
+		"b": "${name|shoutWithError}",
+	}, map[string]interface{}{"name": "hi"})
+	if err != nil {
+		t.Fatalf("TransformData: %v", err)
+	}
+	if result["a"] != "hi!" {
+		t.Fatalf("a = %v, want hi!", result["a"])
+	}
+	if result["b"] != "hi?" {
+		t.Fatalf("b = %v, want hi?", result["b"])
+	}
+
+	errResult, err := TransformData(map[string]interface{}{
+		"c": "${name|alwaysErrors}",
+	}, map[string]interface{}{"name": "hi"})
+	if err != nil {
+		t.Fatalf("TransformData: %v", err)
+	}
+	s, ok := errResult["c"].(string)
+	if !ok || len(s) < len("Error:") || s[:len("Error:")] != "Error:" {
+		t.Fatalf("c = %v, want an \"Error: ...\" string", errResult["c"])
+	}
+}
+
+func TestCoerceArgJSONNumber(t *testing.T) {
+	got, err := coerceArg(json.Number("41"), reflect.TypeOf(0))
+	if err != nil {
+		t.Fatalf("coerceArg: %v", err)
+	}
+	if got.Int() != 41 {
+		t.Fatalf("coerceArg(json.Number) = %v, want 41", got)
+	}
+}
@@ -0,0 +1,365 @@
+// Package lex tokenizes jtran's "${field|keyword(args)}" template
+// language. It replaces the previous regex-based splitting, which broke as
+// soon as a keyword argument itself contained "}", "|", or ",".
+package lex
+
+import (
+	"fmt"
+	"strings"
+)
+
+// JMESPrefix marks a field expression as JMESPath rather than jtran's own
+// field grammar. The lexer uses it to recognize the field position of a
+// "${...}" expression so it can read the whole JMESPath expression as one
+// token instead of tokenizing it as jtran template grammar (JMESPath's own
+// spaces, brackets, and "|" pipe operator would otherwise be sliced up as
+// jtran keyword-pipeline syntax). jtran's ResolveField checks for the same
+// prefix to route the field to the JMESPath resolver.
+const JMESPrefix = "$jmes:"
+
+// Kind identifies the lexical category of a Token.
+type Kind int
+
+const (
+	EOF Kind = iota
+	TEXT
+	IDENT
+	STRING
+	PIPE
+	LPAREN
+	RPAREN
+	COMMA
+	TMPLOPEN
+	TMPLCLOSE
+)
+
+// Token is a single lexical unit produced by the Lexer.
+type Token struct {
+	Kind Kind
+	Text string
+}
+
+type mode int
+
+const (
+	modeText mode = iota
+	modeExpr
+)
+
+// Lexer tokenizes a jtran template string, switching between literal-text
+// mode and expression mode as "${"/"}" pairs are encountered. Within
+// expression mode it tracks parenthesis depth so a bare "|" appearing
+// inside a keyword call's arguments is read as argument content rather
+// than a pipeline/stage delimiter. A bare "," is always a COMMA token, at
+// any depth.
+type Lexer struct {
+	runes      []rune
+	pos        int
+	modeStack  []mode
+	parenStack []int
+	// fieldStack tracks, per expression-nesting level, whether the next
+	// token read in that level is still the leading field token (true) or
+	// a later pipeline/argument token (false). It lets nextExpr recognize
+	// the field position so a "$jmes:"-prefixed field can be read as one
+	// raw JMESPath token instead of jtran template grammar.
+	fieldStack []bool
+	// bareExpr is true when the lexer was created with NewExpr: the
+	// top-level expression mode ends at EOF rather than requiring a
+	// matching "}".
+	bareExpr bool
+}
+
+// New creates a lexer that starts in literal-text mode, for a full schema
+// string that may contain "${...}" expressions.
+func New(input string) *Lexer {
+	return &Lexer{runes: []rune(input), modeStack: []mode{modeText}, parenStack: []int{0}}
+}
+
+// NewExpr creates a lexer that starts directly in expression mode, for a
+// bare "field|keyword(...)" pipeline with no "${}" wrapper.
+func NewExpr(input string) *Lexer {
+	return &Lexer{runes: []rune(input), modeStack: []mode{modeExpr}, parenStack: []int{0}, fieldStack: []bool{true}, bareExpr: true}
+}
+
+func (l *Lexer) curMode() mode      { return l.modeStack[len(l.modeStack)-1] }
+func (l *Lexer) parenDepth() int    { return l.parenStack[len(l.parenStack)-1] }
+func (l *Lexer) incParen(delta int) { l.parenStack[len(l.parenStack)-1] += delta }
+func (l *Lexer) eof() bool          { return l.pos >= len(l.runes) }
+
+// hasPrefix reports whether the unconsumed input starts with s.
+func (l *Lexer) hasPrefix(s string) bool {
+	runes := []rune(s)
+	if l.pos+len(runes) > len(l.runes) {
+		return false
+	}
+	for i, r := range runes {
+		if l.runes[l.pos+i] != r {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *Lexer) peek() rune {
+	if l.eof() {
+		return 0
+	}
+	return l.runes[l.pos]
+}
+
+func (l *Lexer) peekAt(offset int) rune {
+	if l.pos+offset >= len(l.runes) {
+		return 0
+	}
+	return l.runes[l.pos+offset]
+}
+
+// Next returns the next token, or a Token{Kind: EOF} once input is
+// exhausted.
+func (l *Lexer) Next() (Token, error) {
+	if l.curMode() == modeText {
+		return l.nextText()
+	}
+	return l.nextExpr()
+}
+
+func (l *Lexer) nextText() (Token, error) {
+	if l.eof() {
+		return Token{Kind: EOF}, nil
+	}
+	if l.peek() == '$' && l.peekAt(1) == '{' {
+		l.pos += 2
+		l.modeStack = append(l.modeStack, modeExpr)
+		l.parenStack = append(l.parenStack, 0)
+		l.fieldStack = append(l.fieldStack, true)
+		return Token{Kind: TMPLOPEN}, nil
+	}
+
+	var sb strings.Builder
+	for !l.eof() && !(l.peek() == '$' && l.peekAt(1) == '{') {
+		sb.WriteRune(l.runes[l.pos])
+		l.pos++
+	}
+	return Token{Kind: TEXT, Text: sb.String()}, nil
+}
+
+func (l *Lexer) nextExpr() (Token, error) {
+	l.skipSpace()
+	if l.eof() {
+		if l.bareExpr && len(l.modeStack) == 1 {
+			return Token{Kind: EOF}, nil
+		}
+		return Token{}, fmt.Errorf("lex: unexpected end of expression")
+	}
+
+	// The leading field token of an expression gets one chance to opt out
+	// of jtran's own grammar: a "$jmes:"-prefixed field is read whole, so
+	// JMESPath's spaces, brackets, and "|" pipe operator aren't mistaken
+	// for jtran template syntax.
+	atFieldPos := l.fieldStack[len(l.fieldStack)-1]
+	l.fieldStack[len(l.fieldStack)-1] = false
+	if atFieldPos && l.hasPrefix(JMESPrefix) {
+		return l.lexJMESField()
+	}
+
+	switch r := l.peek(); {
+	case r == '}':
+		l.pos++
+		if len(l.modeStack) <= 1 {
+			return Token{}, fmt.Errorf("lex: unmatched '}'")
+		}
+		l.modeStack = l.modeStack[:len(l.modeStack)-1]
+		l.parenStack = l.parenStack[:len(l.parenStack)-1]
+		l.fieldStack = l.fieldStack[:len(l.fieldStack)-1]
+		return Token{Kind: TMPLCLOSE}, nil
+	case r == '$' && l.peekAt(1) == '{':
+		l.pos += 2
+		l.modeStack = append(l.modeStack, modeExpr)
+		l.parenStack = append(l.parenStack, 0)
+		l.fieldStack = append(l.fieldStack, true)
+		return Token{Kind: TMPLOPEN}, nil
+	case r == '(':
+		l.pos++
+		l.incParen(1)
+		return Token{Kind: LPAREN}, nil
+	case r == ')':
+		l.pos++
+		l.incParen(-1)
+		return Token{Kind: RPAREN}, nil
+	case r == ',':
+		l.pos++
+		return Token{Kind: COMMA}, nil
+	case r == '|' && l.parenDepth() == 0:
+		l.pos++
+		return Token{Kind: PIPE}, nil
+	case r == '\'' || r == '"':
+		return l.lexQuoted(r)
+	default:
+		return l.lexBareWord()
+	}
+}
+
+func (l *Lexer) skipSpace() {
+	for !l.eof() {
+		switch l.peek() {
+		case ' ', '\t', '\n', '\r':
+			l.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (l *Lexer) lexQuoted(quote rune) (Token, error) {
+	l.pos++ // opening quote
+	var sb strings.Builder
+	for {
+		if l.eof() {
+			return Token{}, fmt.Errorf("lex: unterminated quoted string")
+		}
+		r := l.runes[l.pos]
+		if r == '\\' && l.pos+1 < len(l.runes) {
+			sb.WriteRune(l.runes[l.pos+1])
+			l.pos += 2
+			continue
+		}
+		if r == quote {
+			l.pos++
+			break
+		}
+		sb.WriteRune(r)
+		l.pos++
+	}
+	return Token{Kind: STRING, Text: sb.String()}, nil
+}
+
+// lexJMESField reads a "$jmes:"-prefixed field expression as a single raw
+// token, tracking "(", "[", "{" depth (and their closes) and treating
+// quoted and backtick-delimited substrings as atomic, so none of JMESPath's
+// own syntax — including spaces and its "|" pipe operator — is mistaken for
+// a jtran stage boundary. A "}" at depth 0 belongs to the enclosing
+// "${...}" and is left unconsumed for the caller to read next.
+func (l *Lexer) lexJMESField() (Token, error) {
+	start := l.pos
+	depth := 0
+	for !l.eof() {
+		switch r := l.peek(); r {
+		case '`':
+			if err := l.skipDelimited('`'); err != nil {
+				return Token{}, err
+			}
+		case '\'', '"':
+			if err := l.skipDelimited(r); err != nil {
+				return Token{}, err
+			}
+		case '(', '[', '{':
+			depth++
+			l.pos++
+		case ')', ']':
+			depth--
+			l.pos++
+		case '}':
+			if depth == 0 {
+				return l.finishJMESField(start)
+			}
+			depth--
+			l.pos++
+		default:
+			l.pos++
+		}
+	}
+	return l.finishJMESField(start)
+}
+
+// skipDelimited advances past a quote- or backtick-delimited run, honoring
+// "\"-escapes of the delimiter, leaving l.pos just past the closing
+// delimiter.
+func (l *Lexer) skipDelimited(delim rune) error {
+	l.pos++ // opening delimiter
+	for {
+		if l.eof() {
+			return fmt.Errorf("lex: unterminated %q in JMESPath expression", delim)
+		}
+		r := l.runes[l.pos]
+		if r == '\\' && l.pos+1 < len(l.runes) {
+			l.pos += 2
+			continue
+		}
+		l.pos++
+		if r == delim {
+			return nil
+		}
+	}
+}
+
+func (l *Lexer) finishJMESField(start int) (Token, error) {
+	text := strings.TrimRight(string(l.runes[start:l.pos]), " \t\n\r")
+	if text == "" {
+		return Token{}, fmt.Errorf("lex: empty JMESPath expression")
+	}
+	return Token{Kind: IDENT, Text: text}, nil
+}
+
+func isBareDelim(r rune) bool {
+	switch r {
+	case '}', '(', ')', ',', '\'', '"', ' ', '\t', '\n', '\r', 0:
+		return true
+	}
+	return false
+}
+
+func (l *Lexer) lexBareWord() (Token, error) {
+	var sb strings.Builder
+	for !l.eof() {
+		r := l.peek()
+		if r == '\\' && l.pos+1 < len(l.runes) {
+			switch l.peekAt(1) {
+			case '|', '}', ',', ')', '\\':
+				sb.WriteRune(l.peekAt(1))
+				l.pos += 2
+				continue
+			}
+		}
+		if r == '$' && l.peekAt(1) == '{' {
+			break
+		}
+		if r == '|' && l.parenDepth() == 0 {
+			break
+		}
+		if isBareDelim(r) {
+			break
+		}
+		sb.WriteRune(r)
+		l.pos++
+	}
+	if sb.Len() == 0 {
+		return Token{}, fmt.Errorf("lex: unexpected character %q", l.peek())
+	}
+	return Token{Kind: IDENT, Text: sb.String()}, nil
+}
+
+// Lex tokenizes a full template string that may contain "${...}"
+// expressions embedded in literal text.
+func Lex(input string) ([]Token, error) {
+	return tokenize(New(input))
+}
+
+// LexExpr tokenizes a bare expression with no surrounding "${}", such as a
+// pipeline-only schema value ("field|keyword(args)").
+func LexExpr(input string) ([]Token, error) {
+	return tokenize(NewExpr(input))
+}
+
+func tokenize(l *Lexer) ([]Token, error) {
+	var toks []Token
+	for {
+		tok, err := l.Next()
+		if err != nil {
+			return nil, err
+		}
+		toks = append(toks, tok)
+		if tok.Kind == EOF {
+			return toks, nil
+		}
+	}
+}
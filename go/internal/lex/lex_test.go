@@ -0,0 +1,95 @@
+package lex
+
+import "testing"
+
+func tokenKinds(t *testing.T, toks []Token) []Kind {
+	t.Helper()
+	kinds := make([]Kind, len(toks))
+	for i, tok := range toks {
+		kinds[i] = tok.Kind
+	}
+	return kinds
+}
+
+func TestLexEscaping(t *testing.T) {
+	toks, err := Lex(`${name|replace(\|,\,)}`)
+	if err != nil {
+		t.Fatalf("Lex: %v", err)
+	}
+	var args []string
+	for i, tok := range toks {
+		if tok.Kind == STRING || (tok.Kind == IDENT && i > 0 && toks[i-1].Kind == LPAREN) {
+			args = append(args, tok.Text)
+		}
+	}
+	if len(args) != 1 || args[0] != "|" {
+		t.Fatalf("first replace arg = %v, want [|]", args)
+	}
+}
+
+func TestLexQuotedArgs(t *testing.T) {
+	toks, err := Lex(`${name|replace('|','/')}`)
+	if err != nil {
+		t.Fatalf("Lex: %v", err)
+	}
+	var strs []string
+	for _, tok := range toks {
+		if tok.Kind == STRING {
+			strs = append(strs, tok.Text)
+		}
+	}
+	if len(strs) != 2 || strs[0] != "|" || strs[1] != "/" {
+		t.Fatalf("quoted args = %v, want [| /]", strs)
+	}
+}
+
+func TestLexNestedTemplateArg(t *testing.T) {
+	toks, err := Lex(`${name|padleft(${padChar},10)}`)
+	if err != nil {
+		t.Fatalf("Lex: %v", err)
+	}
+	var opens int
+	for _, tok := range toks {
+		if tok.Kind == TMPLOPEN {
+			opens++
+		}
+	}
+	if opens != 2 {
+		t.Fatalf("TMPLOPEN count = %d, want 2", opens)
+	}
+}
+
+func TestLexJMESFieldKeepsSpacesAndPipes(t *testing.T) {
+	toks, err := Lex("${$jmes:users[?age > `30`].name | sort(@) | [0]}")
+	if err != nil {
+		t.Fatalf("Lex: %v", err)
+	}
+	kinds := tokenKinds(t, toks)
+	want := []Kind{TMPLOPEN, IDENT, TMPLCLOSE, EOF}
+	if len(kinds) != len(want) {
+		t.Fatalf("tokens = %v, want %v", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("tokens = %v, want %v", kinds, want)
+		}
+	}
+	field := toks[1].Text
+	wantField := "$jmes:users[?age > `30`].name | sort(@) | [0]"
+	if field != wantField {
+		t.Fatalf("field token = %q, want %q", field, wantField)
+	}
+}
+
+func TestLexJMESFieldBareExpr(t *testing.T) {
+	toks, err := LexExpr("$jmes:a.b | [0]")
+	if err != nil {
+		t.Fatalf("LexExpr: %v", err)
+	}
+	if len(toks) != 2 || toks[0].Kind != IDENT || toks[1].Kind != EOF {
+		t.Fatalf("tokens = %+v", toks)
+	}
+	if toks[0].Text != "$jmes:a.b | [0]" {
+		t.Fatalf("field token = %q", toks[0].Text)
+	}
+}
@@ -0,0 +1,635 @@
+package jtran
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/clevengermatt/jtran/internal/lex"
+)
+
+// FieldResolver resolves a field expression against the transform's input
+// data. ResolveField delegates to the active resolver so callers can opt
+// into an alternate expression language without changing the `${...}`
+// template syntax itself.
+type FieldResolver func(fieldName string, data map[string]interface{}) interface{}
+
+// activeFieldResolver backs ResolveField for expressions that don't carry
+// the "$jmes:" prefix. It defaults to the built-in `->`/`[k=v]` resolver.
+var activeFieldResolver FieldResolver = defaultResolveField
+
+// jmesPrefix marks a field expression as JMESPath regardless of which
+// resolver is currently active. It's the same prefix internal/lex's
+// template lexer looks for to read the field position as a raw JMESPath
+// token instead of jtran template grammar.
+const jmesPrefix = lex.JMESPrefix
+
+// SetFieldResolver overrides the resolver used for field expressions that
+// don't carry an explicit "$jmes:" prefix. Pass JMESPathResolver to make
+// JMESPath the default expression language:
+//
+//	jtran.SetFieldResolver(jtran.JMESPathResolver)
+func SetFieldResolver(resolver FieldResolver) {
+	activeFieldResolver = resolver
+}
+
+// JMESPathResolver evaluates fieldName as a JMESPath expression against
+// data. It implements a practical subset of the grammar: identifiers,
+// sub-expressions (`.`), index and slice (`[n]`, `[start:end:step]`),
+// wildcards (`[*]`, `.*`) and flatten (`[]`), filter expressions
+// (`[?expr]`) with comparison and logical operators, multi-select lists
+// and hashes (`[a,b]`, `{x:a,y:b}`), the pipe operator (`|`), and a small
+// set of built-in functions (length, sort, sort_by, keys, values, join,
+// reverse, contains, starts_with, ends_with, type, to_string, to_number).
+// sort_by's second
+// argument accepts the standard `&expr` expression-reference form (the
+// leading `&` is optional, for schemas written before it was supported).
+//
+// Malformed expressions and missing fields both evaluate to nil, matching
+// the behavior of the default resolver.
+func JMESPathResolver(fieldName string, data map[string]interface{}) interface{} {
+	node, err := parseJMESPath(fieldName)
+	if err != nil {
+		return nil
+	}
+	result := node.Eval(data)
+	if proj, ok := result.(jmesProjection); ok {
+		return []interface{}(proj)
+	}
+	return result
+}
+
+func defaultResolveField(fieldName string, data map[string]interface{}) interface{} {
+	if strings.Contains(fieldName, "->") {
+		keys := strings.Split(fieldName, "->")
+		return resolveRecursive(keys, data)
+	}
+	return data[fieldName]
+}
+
+// ResolveField retrieves nested fields from the original data. Expressions
+// prefixed with "$jmes:" are always evaluated as JMESPath; everything else
+// goes through the active resolver (see SetFieldResolver).
+func ResolveField(fieldName string, data map[string]interface{}) interface{} {
+	if strings.HasPrefix(fieldName, jmesPrefix) {
+		return JMESPathResolver(strings.TrimPrefix(fieldName, jmesPrefix), data)
+	}
+	return activeFieldResolver(fieldName, data)
+}
+
+// jmesProjection marks a slice produced by a wildcard, flatten, or filter
+// step so that subsequent sub-expression steps map over it lazily instead
+// of being applied to the slice as a single value. A pipe step collapses a
+// projection back into a plain slice, matching JMESPath's "pipe stops a
+// projection" rule.
+type jmesProjection []interface{}
+
+type jmesNode interface {
+	Eval(current interface{}) interface{}
+}
+
+// --- leaf nodes ---
+
+type jmesIdentity struct{}
+
+func (jmesIdentity) Eval(current interface{}) interface{} { return current }
+
+type jmesField struct{ name string }
+
+func (n jmesField) Eval(current interface{}) interface{} {
+	m, ok := current.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return m[n.name]
+}
+
+type jmesLiteral struct{ value interface{} }
+
+func (n jmesLiteral) Eval(current interface{}) interface{} { return n.value }
+
+// --- composite nodes ---
+
+// jmesSubExpr implements the `.` operator. When left evaluates to a
+// jmesProjection, right is mapped across each element and nil results are
+// dropped, otherwise right is applied once to left's result.
+type jmesSubExpr struct{ left, right jmesNode }
+
+func (n jmesSubExpr) Eval(current interface{}) interface{} {
+	lv := n.left.Eval(current)
+	if proj, ok := lv.(jmesProjection); ok {
+		out := jmesProjection{}
+		for _, item := range proj {
+			rv := n.right.Eval(item)
+			if rv == nil {
+				continue
+			}
+			out = append(out, rv)
+		}
+		return out
+	}
+	return n.right.Eval(lv)
+}
+
+// jmesPipe implements `|`: it forces a projection from left into a plain
+// slice before evaluating right against it.
+type jmesPipe struct{ left, right jmesNode }
+
+func (n jmesPipe) Eval(current interface{}) interface{} {
+	lv := n.left.Eval(current)
+	if proj, ok := lv.(jmesProjection); ok {
+		lv = []interface{}(proj)
+	}
+	return n.right.Eval(lv)
+}
+
+type jmesIndex struct{ index int }
+
+func (n jmesIndex) Eval(current interface{}) interface{} {
+	arr, ok := current.([]interface{})
+	if !ok {
+		return nil
+	}
+	idx := n.index
+	if idx < 0 {
+		idx += len(arr)
+	}
+	if idx < 0 || idx >= len(arr) {
+		return nil
+	}
+	return arr[idx]
+}
+
+type jmesSlice struct{ start, end, step *int }
+
+func (n jmesSlice) Eval(current interface{}) interface{} {
+	arr, ok := current.([]interface{})
+	if !ok {
+		return nil
+	}
+	step := 1
+	if n.step != nil {
+		step = *n.step
+	}
+	if step == 0 {
+		return nil
+	}
+	length := len(arr)
+	normalize := func(v int, forEnd bool) int {
+		if v < 0 {
+			v += length
+		}
+		if v < 0 {
+			v = 0
+		}
+		if v > length {
+			v = length
+		}
+		_ = forEnd
+		return v
+	}
+	var start, end int
+	if step > 0 {
+		start = 0
+		end = length
+	} else {
+		start = length - 1
+		end = -1
+	}
+	if n.start != nil {
+		start = normalize(*n.start, false)
+	}
+	if n.end != nil {
+		end = normalize(*n.end, true)
+	}
+
+	out := jmesProjection{}
+	if step > 0 {
+		for i := start; i < end; i += step {
+			out = append(out, arr[i])
+		}
+	} else {
+		for i := start; i > end; i += step {
+			if i >= 0 && i < length {
+				out = append(out, arr[i])
+			}
+		}
+	}
+	return out
+}
+
+type jmesWildcardArray struct{}
+
+func (jmesWildcardArray) Eval(current interface{}) interface{} {
+	arr, ok := current.([]interface{})
+	if !ok {
+		return nil
+	}
+	out := make(jmesProjection, len(arr))
+	copy(out, arr)
+	return out
+}
+
+type jmesWildcardObject struct{}
+
+func (jmesWildcardObject) Eval(current interface{}) interface{} {
+	m, ok := current.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	out := jmesProjection{}
+	for _, v := range m {
+		out = append(out, v)
+	}
+	return out
+}
+
+type jmesFlatten struct{}
+
+func (jmesFlatten) Eval(current interface{}) interface{} {
+	arr, ok := toSlice(current)
+	if !ok {
+		return nil
+	}
+	out := jmesProjection{}
+	for _, item := range arr {
+		if nested, ok := item.([]interface{}); ok {
+			out = append(out, nested...)
+		} else {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func toSlice(v interface{}) ([]interface{}, bool) {
+	if proj, ok := v.(jmesProjection); ok {
+		return []interface{}(proj), true
+	}
+	arr, ok := v.([]interface{})
+	return arr, ok
+}
+
+type jmesFilter struct{ cond jmesBoolNode }
+
+func (n jmesFilter) Eval(current interface{}) interface{} {
+	arr, ok := toSlice(current)
+	if !ok {
+		return nil
+	}
+	out := jmesProjection{}
+	for _, item := range arr {
+		if n.cond.EvalBool(item) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+type jmesMultiSelectList struct{ items []jmesNode }
+
+func (n jmesMultiSelectList) Eval(current interface{}) interface{} {
+	if current == nil {
+		return nil
+	}
+	out := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		out[i] = item.Eval(current)
+	}
+	return out
+}
+
+type jmesMultiSelectHash struct {
+	keys  []string
+	items []jmesNode
+}
+
+func (n jmesMultiSelectHash) Eval(current interface{}) interface{} {
+	if current == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(n.items))
+	for i, item := range n.items {
+		out[n.keys[i]] = item.Eval(current)
+	}
+	return out
+}
+
+type jmesFunction struct {
+	name string
+	args []jmesNode
+}
+
+func (n jmesFunction) Eval(current interface{}) interface{} {
+	args := make([]interface{}, len(n.args))
+	for i, a := range n.args {
+		v := a.Eval(current)
+		if proj, ok := v.(jmesProjection); ok {
+			v = []interface{}(proj)
+		}
+		args[i] = v
+	}
+	return callJMESFunction(n.name, args, current)
+}
+
+func callJMESFunction(name string, args []interface{}, current interface{}) interface{} {
+	switch name {
+	case "length":
+		if len(args) != 1 {
+			return nil
+		}
+		switch v := args[0].(type) {
+		case string:
+			return float64(len([]rune(v)))
+		case []interface{}:
+			return float64(len(v))
+		case map[string]interface{}:
+			return float64(len(v))
+		}
+		return nil
+	case "keys":
+		if len(args) != 1 {
+			return nil
+		}
+		m, ok := args[0].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		out := make([]interface{}, 0, len(m))
+		for k := range m {
+			out = append(out, k)
+		}
+		return out
+	case "values":
+		if len(args) != 1 {
+			return nil
+		}
+		m, ok := args[0].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		out := make([]interface{}, 0, len(m))
+		for _, v := range m {
+			out = append(out, v)
+		}
+		return out
+	case "join":
+		if len(args) != 2 {
+			return nil
+		}
+		sep, ok := args[0].(string)
+		if !ok {
+			return nil
+		}
+		arr, ok := args[1].([]interface{})
+		if !ok {
+			return nil
+		}
+		parts := make([]string, 0, len(arr))
+		for _, v := range arr {
+			s, ok := v.(string)
+			if !ok {
+				return nil
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, sep)
+	case "reverse":
+		if len(args) != 1 {
+			return nil
+		}
+		arr, ok := args[0].([]interface{})
+		if !ok {
+			return nil
+		}
+		out := make([]interface{}, len(arr))
+		for i, v := range arr {
+			out[len(arr)-1-i] = v
+		}
+		return out
+	case "sort":
+		if len(args) != 1 {
+			return nil
+		}
+		arr, ok := args[0].([]interface{})
+		if !ok {
+			return nil
+		}
+		out := append([]interface{}{}, arr...)
+		sort.SliceStable(out, func(i, j int) bool { return jmesLess(out[i], out[j]) })
+		return out
+	case "sort_by":
+		if len(args) != 2 {
+			return nil
+		}
+		arr, ok := args[0].([]interface{})
+		if !ok {
+			return nil
+		}
+		exprNode, ok := args[1].(jmesNode)
+		if !ok {
+			return nil
+		}
+		out := append([]interface{}{}, arr...)
+		sort.SliceStable(out, func(i, j int) bool {
+			return jmesLess(exprNode.Eval(out[i]), exprNode.Eval(out[j]))
+		})
+		return out
+	case "contains":
+		if len(args) != 2 {
+			return nil
+		}
+		switch subject := args[0].(type) {
+		case string:
+			s, ok := args[1].(string)
+			return ok && strings.Contains(subject, s)
+		case []interface{}:
+			for _, v := range subject {
+				if jmesEquals(v, args[1]) {
+					return true
+				}
+			}
+			return false
+		}
+		return false
+	case "starts_with":
+		if len(args) != 2 {
+			return nil
+		}
+		s, ok1 := args[0].(string)
+		prefix, ok2 := args[1].(string)
+		return ok1 && ok2 && strings.HasPrefix(s, prefix)
+	case "ends_with":
+		if len(args) != 2 {
+			return nil
+		}
+		s, ok1 := args[0].(string)
+		suffix, ok2 := args[1].(string)
+		return ok1 && ok2 && strings.HasSuffix(s, suffix)
+	case "type":
+		if len(args) != 1 {
+			return nil
+		}
+		switch args[0].(type) {
+		case nil:
+			return "null"
+		case string:
+			return "string"
+		case float64, int:
+			return "number"
+		case bool:
+			return "boolean"
+		case []interface{}:
+			return "array"
+		case map[string]interface{}:
+			return "object"
+		}
+		return "null"
+	case "to_string":
+		if len(args) != 1 {
+			return nil
+		}
+		if s, ok := args[0].(string); ok {
+			return s
+		}
+		b, err := json.Marshal(args[0])
+		if err != nil {
+			return nil
+		}
+		return string(b)
+	case "to_number":
+		if len(args) != 1 {
+			return nil
+		}
+		switch v := args[0].(type) {
+		case float64:
+			return v
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil
+			}
+			return f
+		}
+		return nil
+	}
+	return nil
+}
+
+// --- boolean / filter-condition nodes ---
+
+type jmesBoolNode interface {
+	EvalBool(current interface{}) bool
+}
+
+type jmesComparison struct {
+	left, right jmesNode
+	op          string
+}
+
+func (n jmesComparison) EvalBool(current interface{}) bool {
+	lv := n.left.Eval(current)
+	rv := n.right.Eval(current)
+	switch n.op {
+	case "==":
+		return jmesEquals(lv, rv)
+	case "!=":
+		return !jmesEquals(lv, rv)
+	case "<", "<=", ">", ">=":
+		lf, lok := toFloat(lv)
+		rf, rok := toFloat(rv)
+		if !lok || !rok {
+			return false
+		}
+		switch n.op {
+		case "<":
+			return lf < rf
+		case "<=":
+			return lf <= rf
+		case ">":
+			return lf > rf
+		case ">=":
+			return lf >= rf
+		}
+	}
+	return false
+}
+
+type jmesTruthy struct{ node jmesNode }
+
+func (n jmesTruthy) EvalBool(current interface{}) bool {
+	return isTruthy(n.node.Eval(current))
+}
+
+type jmesAnd struct{ left, right jmesBoolNode }
+
+func (n jmesAnd) EvalBool(current interface{}) bool {
+	return n.left.EvalBool(current) && n.right.EvalBool(current)
+}
+
+type jmesOr struct{ left, right jmesBoolNode }
+
+func (n jmesOr) EvalBool(current interface{}) bool {
+	return n.left.EvalBool(current) || n.right.EvalBool(current)
+}
+
+type jmesNot struct{ node jmesBoolNode }
+
+func (n jmesNot) EvalBool(current interface{}) bool { return !n.node.EvalBool(current) }
+
+func isTruthy(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return val
+	case string:
+		return val != ""
+	case []interface{}:
+		return len(val) > 0
+	case jmesProjection:
+		return len(val) > 0
+	case map[string]interface{}:
+		return len(val) > 0
+	}
+	return true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func jmesEquals(a, b interface{}) bool {
+	if af, aok := toFloat(a); aok {
+		if bf, bok := toFloat(b); bok {
+			return af == bf
+		}
+	}
+	ab, err1 := json.Marshal(a)
+	bb, err2 := json.Marshal(b)
+	return err1 == nil && err2 == nil && string(ab) == string(bb)
+}
+
+func jmesLess(a, b interface{}) bool {
+	if af, aok := toFloat(a); aok {
+		if bf, bok := toFloat(b); bok {
+			return af < bf
+		}
+	}
+	as, aok := a.(string)
+	bs, bok := b.(string)
+	if aok && bok {
+		return as < bs
+	}
+	return false
+}
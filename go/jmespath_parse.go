@@ -0,0 +1,562 @@
+package jtran
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jmesTok is a single lexical token of a JMESPath expression.
+type jmesTok struct {
+	kind string // "ident", "number", "string", "literal", "op", "eof"
+	text string
+	num  interface{} // decoded value for "literal" tokens
+}
+
+func lexJMESPath(expr string) ([]jmesTok, error) {
+	var toks []jmesTok
+	runes := []rune(expr)
+	i := 0
+	for i < len(runes) {
+		r := runes[i]
+		switch {
+		case r == ' ' || r == '\t' || r == '\n':
+			i++
+		case r == '.' || r == '[' || r == ']' || r == '{' || r == '}' || r == ',' || r == ':' || r == '@' || r == '(' || r == ')':
+			toks = append(toks, jmesTok{kind: "op", text: string(r)})
+			i++
+		case r == '*':
+			toks = append(toks, jmesTok{kind: "op", text: "*"})
+			i++
+		case r == '?':
+			toks = append(toks, jmesTok{kind: "op", text: "?"})
+			i++
+		case r == '|':
+			if i+1 < len(runes) && runes[i+1] == '|' {
+				toks = append(toks, jmesTok{kind: "op", text: "||"})
+				i += 2
+			} else {
+				toks = append(toks, jmesTok{kind: "op", text: "|"})
+				i++
+			}
+		case r == '&':
+			if i+1 < len(runes) && runes[i+1] == '&' {
+				toks = append(toks, jmesTok{kind: "op", text: "&&"})
+				i += 2
+			} else {
+				toks = append(toks, jmesTok{kind: "op", text: "&"})
+				i++
+			}
+		case r == '!':
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				toks = append(toks, jmesTok{kind: "op", text: "!="})
+				i += 2
+			} else {
+				toks = append(toks, jmesTok{kind: "op", text: "!"})
+				i++
+			}
+		case r == '=':
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				toks = append(toks, jmesTok{kind: "op", text: "=="})
+				i += 2
+			} else {
+				return nil, fmt.Errorf("jmespath: unexpected '='")
+			}
+		case r == '<':
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				toks = append(toks, jmesTok{kind: "op", text: "<="})
+				i += 2
+			} else {
+				toks = append(toks, jmesTok{kind: "op", text: "<"})
+				i++
+			}
+		case r == '>':
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				toks = append(toks, jmesTok{kind: "op", text: ">="})
+				i += 2
+			} else {
+				toks = append(toks, jmesTok{kind: "op", text: ">"})
+				i++
+			}
+		case r == '`':
+			j := i + 1
+			var sb strings.Builder
+			for j < len(runes) && runes[j] != '`' {
+				if runes[j] == '\\' && j+1 < len(runes) && runes[j+1] == '`' {
+					sb.WriteRune('`')
+					j += 2
+					continue
+				}
+				sb.WriteRune(runes[j])
+				j++
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("jmespath: unterminated literal")
+			}
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(sb.String()), &decoded); err != nil {
+				return nil, fmt.Errorf("jmespath: invalid literal `%s`: %v", sb.String(), err)
+			}
+			toks = append(toks, jmesTok{kind: "literal", num: decoded})
+			i = j + 1
+		case r == '\'' || r == '"':
+			quote := r
+			j := i + 1
+			var sb strings.Builder
+			for j < len(runes) && runes[j] != quote {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					sb.WriteRune(runes[j+1])
+					j += 2
+					continue
+				}
+				sb.WriteRune(runes[j])
+				j++
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("jmespath: unterminated quote")
+			}
+			kind := "string"
+			if quote == '"' {
+				kind = "ident"
+			}
+			toks = append(toks, jmesTok{kind: kind, text: sb.String()})
+			i = j + 1
+		case r == '-' || (r >= '0' && r <= '9'):
+			j := i + 1
+			for j < len(runes) && runes[j] >= '0' && runes[j] <= '9' {
+				j++
+			}
+			toks = append(toks, jmesTok{kind: "number", text: string(runes[i:j])})
+			i = j
+		case isIdentStart(r):
+			j := i + 1
+			for j < len(runes) && isIdentPart(runes[j]) {
+				j++
+			}
+			toks = append(toks, jmesTok{kind: "ident", text: string(runes[i:j])})
+			i = j
+		default:
+			return nil, fmt.Errorf("jmespath: unexpected character %q", r)
+		}
+	}
+	toks = append(toks, jmesTok{kind: "eof"})
+	return toks, nil
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || (r >= '0' && r <= '9')
+}
+
+// jmesParser is a hand-written recursive-descent parser over the token
+// stream produced by lexJMESPath.
+type jmesParser struct {
+	toks []jmesTok
+	pos  int
+}
+
+func parseJMESPath(expr string) (jmesNode, error) {
+	toks, err := lexJMESPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &jmesParser{toks: toks}
+	node, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != "eof" {
+		return nil, fmt.Errorf("jmespath: unexpected trailing token %q", p.peek().text)
+	}
+	return node, nil
+}
+
+func (p *jmesParser) peek() jmesTok { return p.toks[p.pos] }
+func (p *jmesParser) advance() jmesTok {
+	t := p.toks[p.pos]
+	if p.pos < len(p.toks)-1 {
+		p.pos++
+	}
+	return t
+}
+
+func (p *jmesParser) isOp(text string) bool {
+	return p.peek().kind == "op" && p.peek().text == text
+}
+
+func (p *jmesParser) expectOp(text string) error {
+	if !p.isOp(text) {
+		return fmt.Errorf("jmespath: expected %q, got %q", text, p.peek().text)
+	}
+	p.advance()
+	return nil
+}
+
+func (p *jmesParser) parsePipe() (jmesNode, error) {
+	left, err := p.parseChain()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("|") {
+		p.advance()
+		right, err := p.parseChain()
+		if err != nil {
+			return nil, err
+		}
+		left = jmesPipe{left, right}
+	}
+	return left, nil
+}
+
+// parseChain parses a sequence of dot/bracket steps forming a single
+// (non-pipe) expression, e.g. "users[?age > `30`].name".
+func (p *jmesParser) parseChain() (jmesNode, error) {
+	node, err := p.parseLeadingTerm()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.isOp("."):
+			p.advance()
+			right, err := p.parseAfterDot()
+			if err != nil {
+				return nil, err
+			}
+			node = jmesSubExpr{node, right}
+		case p.isOp("["):
+			right, err := p.parseBracket()
+			if err != nil {
+				return nil, err
+			}
+			node = jmesSubExpr{node, right}
+		default:
+			return node, nil
+		}
+	}
+}
+
+func (p *jmesParser) parseLeadingTerm() (jmesNode, error) {
+	switch {
+	case p.isOp("["):
+		return p.parseBracketOnIdentity()
+	case p.isOp("{"):
+		return p.parseHash()
+	case p.isOp("@"):
+		p.advance()
+		return jmesIdentity{}, nil
+	case p.isOp("*"):
+		p.advance()
+		return jmesSubExpr{jmesIdentity{}, jmesWildcardObject{}}, nil
+	case p.peek().kind == "ident":
+		return p.parseIdentOrFunction()
+	}
+	return nil, fmt.Errorf("jmespath: unexpected token %q", p.peek().text)
+}
+
+func (p *jmesParser) parseAfterDot() (jmesNode, error) {
+	switch {
+	case p.isOp("*"):
+		p.advance()
+		return jmesWildcardObject{}, nil
+	case p.isOp("["):
+		return p.parseBracketOnIdentity()
+	case p.isOp("{"):
+		return p.parseHash()
+	case p.peek().kind == "ident":
+		return p.parseIdentOrFunction()
+	}
+	return nil, fmt.Errorf("jmespath: expected identifier after '.', got %q", p.peek().text)
+}
+
+func (p *jmesParser) parseIdentOrFunction() (jmesNode, error) {
+	name := p.advance().text
+	if p.isOp("(") {
+		p.advance()
+		var args []jmesNode
+		for !p.isOp(")") {
+			if name == "sort_by" && len(args) == 1 {
+				// second arg to sort_by is an expression-reference,
+				// evaluated per element later rather than against the
+				// current value now. The standard "&expr" form's leading
+				// "&" is optional here for backward compatibility with
+				// schemas written before it was supported.
+				if p.isOp("&") {
+					p.advance()
+				}
+				argNode, err := p.parseChain()
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, jmesLiteral{argNode})
+			} else {
+				argNode, err := p.parsePipe()
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, argNode)
+			}
+			if p.isOp(",") {
+				p.advance()
+				continue
+			}
+			break
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		return jmesFunction{name: name, args: args}, nil
+	}
+	return jmesField{name: name}, nil
+}
+
+// parseBracketOnIdentity parses a leading "[...]" as applying to the
+// current value (identity), used when brackets start a chain or follow a
+// dot directly.
+func (p *jmesParser) parseBracketOnIdentity() (jmesNode, error) {
+	node, err := p.parseBracket()
+	if err != nil {
+		return nil, err
+	}
+	return jmesSubExpr{jmesIdentity{}, node}, nil
+}
+
+func (p *jmesParser) parseBracket() (jmesNode, error) {
+	if err := p.expectOp("["); err != nil {
+		return nil, err
+	}
+	switch {
+	case p.isOp("]"):
+		p.advance()
+		return jmesFlatten{}, nil
+	case p.isOp("*"):
+		p.advance()
+		if err := p.expectOp("]"); err != nil {
+			return nil, err
+		}
+		return jmesWildcardArray{}, nil
+	case p.isOp("?"):
+		p.advance()
+		cond, err := p.parseOrCond()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp("]"); err != nil {
+			return nil, err
+		}
+		return jmesFilter{cond: cond}, nil
+	}
+
+	// Multi-select list, index, or slice.
+	if p.peek().kind == "number" && p.toks[p.pos+1].kind == "op" && (p.toks[p.pos+1].text == "]" || p.toks[p.pos+1].text == ":") {
+		return p.parseIndexOrSlice()
+	}
+	if p.isOp(":") {
+		return p.parseIndexOrSlice()
+	}
+
+	first, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOp(",") {
+		items := []jmesNode{first}
+		for p.isOp(",") {
+			p.advance()
+			item, err := p.parsePipe()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		if err := p.expectOp("]"); err != nil {
+			return nil, err
+		}
+		return jmesMultiSelectList{items: items}, nil
+	}
+	if err := p.expectOp("]"); err != nil {
+		return nil, err
+	}
+	return first, nil
+}
+
+func (p *jmesParser) parseIndexOrSlice() (jmesNode, error) {
+	readInt := func() (*int, error) {
+		if p.isOp(":") || p.isOp("]") {
+			return nil, nil
+		}
+		if p.peek().kind != "number" {
+			return nil, fmt.Errorf("jmespath: expected integer, got %q", p.peek().text)
+		}
+		n, err := strconv.Atoi(p.advance().text)
+		if err != nil {
+			return nil, err
+		}
+		return &n, nil
+	}
+
+	start, err := readInt()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOp("]") {
+		p.advance()
+		if start == nil {
+			return nil, fmt.Errorf("jmespath: empty index")
+		}
+		return jmesIndex{index: *start}, nil
+	}
+	if err := p.expectOp(":"); err != nil {
+		return nil, err
+	}
+	end, err := readInt()
+	if err != nil {
+		return nil, err
+	}
+	var step *int
+	if p.isOp(":") {
+		p.advance()
+		step, err = readInt()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectOp("]"); err != nil {
+		return nil, err
+	}
+	return jmesSlice{start: start, end: end, step: step}, nil
+}
+
+func (p *jmesParser) parseHash() (jmesNode, error) {
+	if err := p.expectOp("{"); err != nil {
+		return nil, err
+	}
+	var keys []string
+	var items []jmesNode
+	for !p.isOp("}") {
+		if p.peek().kind != "ident" {
+			return nil, fmt.Errorf("jmespath: expected key in hash, got %q", p.peek().text)
+		}
+		key := p.advance().text
+		if err := p.expectOp(":"); err != nil {
+			return nil, err
+		}
+		val, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		items = append(items, val)
+		if p.isOp(",") {
+			p.advance()
+			continue
+		}
+		break
+	}
+	if err := p.expectOp("}"); err != nil {
+		return nil, err
+	}
+	return jmesMultiSelectHash{keys: keys, items: items}, nil
+}
+
+// --- filter-condition (boolean) grammar ---
+
+func (p *jmesParser) parseOrCond() (jmesBoolNode, error) {
+	left, err := p.parseAndCond()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		p.advance()
+		right, err := p.parseAndCond()
+		if err != nil {
+			return nil, err
+		}
+		left = jmesOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *jmesParser) parseAndCond() (jmesBoolNode, error) {
+	left, err := p.parseNotCond()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		p.advance()
+		right, err := p.parseNotCond()
+		if err != nil {
+			return nil, err
+		}
+		left = jmesAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *jmesParser) parseNotCond() (jmesBoolNode, error) {
+	if p.isOp("!") {
+		p.advance()
+		inner, err := p.parseNotCond()
+		if err != nil {
+			return nil, err
+		}
+		return jmesNot{inner}, nil
+	}
+	return p.parsePrimaryCond()
+}
+
+func (p *jmesParser) parsePrimaryCond() (jmesBoolNode, error) {
+	if p.isOp("(") {
+		p.advance()
+		inner, err := p.parseOrCond()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *jmesParser) parseComparison() (jmesBoolNode, error) {
+	left, err := p.parseCondOperand()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.isOp(op) {
+			p.advance()
+			right, err := p.parseCondOperand()
+			if err != nil {
+				return nil, err
+			}
+			return jmesComparison{left: left, right: right, op: op}, nil
+		}
+	}
+	return jmesTruthy{node: left}, nil
+}
+
+func (p *jmesParser) parseCondOperand() (jmesNode, error) {
+	if p.peek().kind == "literal" {
+		v := p.advance().num
+		return jmesLiteral{value: v}, nil
+	}
+	if p.peek().kind == "string" {
+		v := p.advance().text
+		return jmesLiteral{value: v}, nil
+	}
+	if p.peek().kind == "number" {
+		n, err := strconv.Atoi(p.advance().text)
+		if err != nil {
+			return nil, err
+		}
+		return jmesLiteral{value: float64(n)}, nil
+	}
+	return p.parseChain()
+}
@@ -0,0 +1,74 @@
+package jtran
+
+import "testing"
+
+// TestJMESPathResolverThroughTemplate exercises this request's own
+// headline example end-to-end through TransformData: a "$jmes:"-prefixed
+// filter/projection/pipe expression embedded in a "${...}" schema value.
+func TestJMESPathResolverThroughTemplate(t *testing.T) {
+	schema := map[string]interface{}{
+		"topName": "${$jmes:users[?age > `30`].name | sort(@) | [0]}",
+	}
+	data := map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"name": "Zed", "age": 45.0},
+			map[string]interface{}{"name": "Amy", "age": 31.0},
+			map[string]interface{}{"name": "Bo", "age": 20.0},
+		},
+	}
+
+	result, err := TransformData(schema, data)
+	if err != nil {
+		t.Fatalf("TransformData: %v", err)
+	}
+	if result["topName"] != "Amy" {
+		t.Fatalf("topName = %v, want Amy", result["topName"])
+	}
+}
+
+// TestSortByExprRefAndBareForm checks sort_by's second argument accepts
+// both the standard "&expr" expression-reference form and the bare "expr"
+// form this implementation historically parsed.
+func TestSortByExprRefAndBareForm(t *testing.T) {
+	data := map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"name": "Cole", "age": 45.0},
+			map[string]interface{}{"name": "Amy", "age": 31.0},
+		},
+	}
+	for _, expr := range []string{"sort_by(users, &age)", "sort_by(users, age)"} {
+		got := JMESPathResolver(expr, data)
+		arr, ok := got.([]interface{})
+		if !ok || len(arr) != 2 {
+			t.Fatalf("%s = %v", expr, got)
+		}
+		if arr[0].(map[string]interface{})["name"] != "Amy" {
+			t.Fatalf("%s did not sort ascending by age: %v", expr, got)
+		}
+	}
+}
+
+// TestJMESPathFunctionArgCountGuards ensures the built-in functions that
+// index into args don't panic when called with too few arguments, which a
+// syntactically valid JMESPath expression (e.g. "type()") can do.
+func TestJMESPathFunctionArgCountGuards(t *testing.T) {
+	exprs := []string{
+		"type()",
+		"to_string()",
+		"to_number()",
+		"starts_with(`\"a\"`)",
+		"ends_with(`\"a\"`)",
+	}
+	for _, expr := range exprs {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%s panicked: %v", expr, r)
+				}
+			}()
+			if got := JMESPathResolver(expr, map[string]interface{}{}); got != nil {
+				t.Errorf("%s = %v, want nil", expr, got)
+			}
+		}()
+	}
+}
@@ -0,0 +1,218 @@
+package jtran
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Streamer applies a CompiledSchema to a large input one record at a
+// time, so transforming a multi-gigabyte document never requires holding
+// more than a handful of records in memory.
+type Streamer struct {
+	compiled *CompiledSchema
+	batch    int
+}
+
+// NewStreamer creates a Streamer that applies compiled to every record
+// read from Transform's input.
+func NewStreamer(compiled *CompiledSchema) *Streamer {
+	return &Streamer{compiled: compiled, batch: 1}
+}
+
+// Batch sets the number of records a worker pool processes concurrently.
+// Output order always matches input order regardless of n. n <= 1
+// disables the worker pool (the default); Batch returns the Streamer so
+// calls can be chained off NewStreamer.
+func (s *Streamer) Batch(n int) *Streamer {
+	s.batch = n
+	return s
+}
+
+// Transform reads records from r and writes their transformed results to
+// w as newline-delimited JSON. r may itself be newline-delimited JSON
+// objects or a single top-level JSON array of objects; both are read
+// incrementally via json.Decoder's Token/Decode methods, so Transform
+// never buffers more than one input record (or one batch, with Batch
+// set) at a time.
+func (s *Streamer) Transform(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	arrayMode, err := looksLikeArray(br)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("jtran: streamer: %v", err)
+	}
+
+	dec := json.NewDecoder(br)
+	if arrayMode {
+		if _, err := dec.Token(); err != nil {
+			return fmt.Errorf("jtran: streamer: %v", err)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	next := nextRecordFunc(dec, arrayMode)
+	if s.batch > 1 {
+		return s.transformBatched(next, enc)
+	}
+	return s.transformSequential(next, enc)
+}
+
+// looksLikeArray peeks past leading whitespace to decide whether r holds
+// a single top-level JSON array or a sequence of newline-delimited JSON
+// values, without consuming anything a json.Decoder still needs to see.
+func looksLikeArray(br *bufio.Reader) (bool, error) {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return false, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\n', '\r':
+			br.Discard(1)
+		case '[':
+			return true, nil
+		default:
+			return false, nil
+		}
+	}
+}
+
+// nextRecordFunc returns a function yielding one decoded record at a
+// time, terminating with io.EOF, regardless of whether dec is positioned
+// inside an array (its opening "[" already consumed) or reading bare
+// newline-delimited values.
+func nextRecordFunc(dec *json.Decoder, arrayMode bool) func() (map[string]interface{}, error) {
+	if arrayMode {
+		return func() (map[string]interface{}, error) {
+			if !dec.More() {
+				return nil, io.EOF
+			}
+			var record map[string]interface{}
+			if err := dec.Decode(&record); err != nil {
+				return nil, err
+			}
+			return record, nil
+		}
+	}
+	return func() (map[string]interface{}, error) {
+		var record map[string]interface{}
+		if err := dec.Decode(&record); err != nil {
+			return nil, err
+		}
+		return record, nil
+	}
+}
+
+func (s *Streamer) transformSequential(next func() (map[string]interface{}, error), enc *json.Encoder) error {
+	for {
+		record, err := next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("jtran: streamer: decode record: %v", err)
+		}
+
+		result, err := s.compiled.Transform(record)
+		if err != nil {
+			return fmt.Errorf("jtran: streamer: transform record: %v", err)
+		}
+		if err := enc.Encode(result); err != nil {
+			return fmt.Errorf("jtran: streamer: encode result: %v", err)
+		}
+	}
+}
+
+// batchResult is one worker's output, tagged with its input position so
+// the reorder buffer in transformBatched can restore input order.
+type batchResult struct {
+	index int
+	value map[string]interface{}
+	err   error
+}
+
+// transformBatched fans records out across s.batch workers and writes
+// their results through a reorder buffer keyed by input position, so
+// output order matches input order even though workers finish out of
+// order. The results channel is always fully drained (rather than
+// returning as soon as the first error is seen) so no worker ever blocks
+// forever trying to send a result nobody is reading.
+func (s *Streamer) transformBatched(next func() (map[string]interface{}, error), enc *json.Encoder) error {
+	type job struct {
+		index  int
+		record map[string]interface{}
+	}
+	jobs := make(chan job)
+	results := make(chan batchResult, s.batch)
+	// inFlight caps records read but not yet written at s.batch, so a slow
+	// early record can't let the reorder buffer grow without bound.
+	inFlight := make(chan struct{}, s.batch)
+
+	var workers sync.WaitGroup
+	for i := 0; i < s.batch; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for j := range jobs {
+				value, err := s.compiled.Transform(j.record)
+				results <- batchResult{index: j.index, value: value, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		index := 0
+		for {
+			inFlight <- struct{}{}
+			record, err := next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				results <- batchResult{index: index, err: fmt.Errorf("decode record: %v", err)}
+				return
+			}
+			jobs <- job{index: index, record: record}
+			index++
+		}
+	}()
+
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	var firstErr error
+	pending := make(map[int]batchResult)
+	nextWrite := 0
+	for res := range results {
+		pending[res.index] = res
+		for {
+			r, ok := pending[nextWrite]
+			if !ok {
+				break
+			}
+			delete(pending, nextWrite)
+			nextWrite++
+			<-inFlight
+
+			if firstErr != nil {
+				continue
+			}
+			if r.err != nil {
+				firstErr = fmt.Errorf("jtran: streamer: %v", r.err)
+				continue
+			}
+			if err := enc.Encode(r.value); err != nil {
+				firstErr = fmt.Errorf("jtran: streamer: encode result: %v", err)
+			}
+		}
+	}
+	return firstErr
+}
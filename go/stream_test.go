@@ -0,0 +1,55 @@
+package jtran
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStreamerBatchPreservesOrder transforms records whose processing
+// order would otherwise be scrambled by a worker pool (earlier-indexed
+// records are made to take longer, so they tend to finish last) and
+// checks the output is still in input order.
+func TestStreamerBatchPreservesOrder(t *testing.T) {
+	const n = 20
+	RegisterKeyword("reverseDelay", func(value interface{}, ctx *Context, args []Value) (interface{}, error) {
+		idx, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("reverseDelay expects a number")
+		}
+		time.Sleep(time.Duration(n-int(idx)) * time.Millisecond)
+		return idx, nil
+	})
+
+	compiled, err := Compile(map[string]interface{}{"idx": "idx|reverseDelay"})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	var input bytes.Buffer
+	enc := json.NewEncoder(&input)
+	for i := 0; i < n; i++ {
+		if err := enc.Encode(map[string]interface{}{"idx": i}); err != nil {
+			t.Fatalf("encode input: %v", err)
+		}
+	}
+
+	var output bytes.Buffer
+	if err := NewStreamer(compiled).Batch(8).Transform(&input, &output); err != nil {
+		t.Fatalf("Transform: %v", err)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(output.String()))
+	for i := 0; i < n; i++ {
+		var record map[string]interface{}
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("decode output record %d: %v", i, err)
+		}
+		if got := record["idx"]; got != float64(i) {
+			t.Fatalf("output record %d has idx %v, want %d (order not preserved)", i, got, i)
+		}
+	}
+}
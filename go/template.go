@@ -0,0 +1,356 @@
+package jtran
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/clevengermatt/jtran/internal/lex"
+)
+
+// builderPool recycles the strings.Builder used to expand "${...}" template
+// parts so compiled-schema transforms don't allocate one per field.
+var builderPool = sync.Pool{
+	New: func() interface{} { return new(strings.Builder) },
+}
+
+// Context carries per-record state through a schema evaluation: the
+// original input data (so keyword arguments can resolve nested "${...}"
+// expressions against it) and a small variable bag handlers can use to
+// pass information to each other, such as the field currently being
+// evaluated.
+type Context struct {
+	Data map[string]interface{}
+	vars map[string]interface{}
+}
+
+// Set stores a variable in the context, such as "currentKey".
+func (c *Context) Set(key string, value interface{}) {
+	if c.vars == nil {
+		c.vars = make(map[string]interface{})
+	}
+	c.vars[key] = value
+}
+
+// Get retrieves a variable previously stored with Set.
+func (c *Context) Get(key string) interface{} {
+	if c.vars == nil {
+		return nil
+	}
+	return c.vars[key]
+}
+
+// Value is a keyword argument: either a literal as written in the schema,
+// or a nested "${...}" expression resolved against the Context's data at
+// evaluation time.
+type Value struct {
+	text string
+	expr *Expr
+}
+
+// Text returns the argument's raw source text. For a literal argument this
+// is its value; for a nested "${...}" argument this is the field path,
+// which is useful when a handler wants the path itself rather than its
+// resolved value. The stock handlers, foreach included, resolve their
+// arguments instead.
+func (v Value) Text() string { return v.text }
+
+// Resolve evaluates the argument: a nested "${...}" argument is evaluated
+// against ctx, everything else is returned as its literal text.
+func (v Value) Resolve(ctx *Context) (interface{}, error) {
+	if v.expr != nil {
+		return evalExpr(v.expr, ctx)
+	}
+	return v.text, nil
+}
+
+// Stage is one "|keyword(args)" step of a pipeline. Handler is resolved
+// once at parse time against the keyword registries so evaluation never
+// has to look it up by name.
+type Stage struct {
+	Name    string
+	Args    []Value
+	Handler KeywordHandler
+}
+
+// Expr is a parsed "field|stage|stage..." pipeline, with or without a
+// surrounding "${...}".
+type Expr struct {
+	Field  string
+	Stages []Stage
+}
+
+// TemplatePart is one piece of a template string: either literal text or
+// an embedded expression.
+type TemplatePart struct {
+	Text string
+	Expr *Expr
+}
+
+// Template is a parsed "${...}"-bearing schema string.
+type Template struct {
+	Parts []TemplatePart
+}
+
+// ParseTemplate parses a schema string that embeds one or more
+// "${field|keyword(args)}" expressions in literal text.
+func ParseTemplate(input string) (*Template, error) {
+	toks, err := lex.Lex(input)
+	if err != nil {
+		return nil, fmt.Errorf("template: %v", err)
+	}
+	p := &parser{toks: toks}
+	var parts []TemplatePart
+	for {
+		tok := p.advance()
+		switch tok.Kind {
+		case lex.EOF:
+			return &Template{Parts: parts}, nil
+		case lex.TEXT:
+			if tok.Text != "" {
+				parts = append(parts, TemplatePart{Text: tok.Text})
+			}
+		case lex.TMPLOPEN:
+			expr, err := p.parseExpr(true)
+			if err != nil {
+				return nil, fmt.Errorf("template: %v", err)
+			}
+			parts = append(parts, TemplatePart{Expr: expr})
+		default:
+			return nil, fmt.Errorf("template: unexpected token %q", tok.Text)
+		}
+	}
+}
+
+// ParsePipeline parses a bare "field|keyword(args)|..." schema string with
+// no surrounding "${}".
+func ParsePipeline(input string) (*Expr, error) {
+	toks, err := lex.LexExpr(input)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline: %v", err)
+	}
+	p := &parser{toks: toks}
+	expr, err := p.parseExpr(false)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline: %v", err)
+	}
+	return expr, nil
+}
+
+type parser struct {
+	toks []lex.Token
+	pos  int
+}
+
+func (p *parser) peek() lex.Token { return p.toks[p.pos] }
+
+func (p *parser) advance() lex.Token {
+	tok := p.toks[p.pos]
+	if p.pos < len(p.toks)-1 {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) expect(kind lex.Kind) (lex.Token, error) {
+	if p.peek().Kind != kind {
+		return lex.Token{}, fmt.Errorf("unexpected token %q", p.peek().Text)
+	}
+	return p.advance(), nil
+}
+
+// parseExpr parses a "field(|stage)*" pipeline. When closesWithBrace is
+// true it consumes the closing "}" of a "${...}"; otherwise it expects
+// EOF.
+func (p *parser) parseExpr(closesWithBrace bool) (*Expr, error) {
+	fieldTok, err := p.expect(lex.IDENT)
+	if err != nil {
+		return nil, fmt.Errorf("expected field expression: %v", err)
+	}
+	expr := &Expr{Field: fieldTok.Text}
+
+	for p.peek().Kind == lex.PIPE {
+		p.advance()
+		nameTok, err := p.expect(lex.IDENT)
+		if err != nil {
+			return nil, fmt.Errorf("expected keyword name: %v", err)
+		}
+		stage := Stage{Name: nameTok.Text}
+
+		if p.peek().Kind == lex.LPAREN {
+			p.advance()
+			for p.peek().Kind != lex.RPAREN {
+				val, err := p.parseValue()
+				if err != nil {
+					return nil, err
+				}
+				stage.Args = append(stage.Args, val)
+				if p.peek().Kind == lex.COMMA {
+					p.advance()
+					continue
+				}
+				break
+			}
+			if _, err := p.expect(lex.RPAREN); err != nil {
+				return nil, fmt.Errorf("unterminated keyword arguments: %v", err)
+			}
+		}
+
+		stage.Handler = lookupKeywordHandler(stage.Name)
+		expr.Stages = append(expr.Stages, stage)
+	}
+
+	if closesWithBrace {
+		if _, err := p.expect(lex.TMPLCLOSE); err != nil {
+			return nil, fmt.Errorf("unterminated \"${\": %v", err)
+		}
+	} else if p.peek().Kind != lex.EOF {
+		return nil, fmt.Errorf("unexpected trailing token %q", p.peek().Text)
+	}
+	return expr, nil
+}
+
+func (p *parser) parseValue() (Value, error) {
+	switch tok := p.peek(); tok.Kind {
+	case lex.STRING, lex.IDENT:
+		p.advance()
+		return Value{text: tok.Text}, nil
+	case lex.TMPLOPEN:
+		p.advance()
+		nested, err := p.parseExpr(true)
+		if err != nil {
+			return Value{}, err
+		}
+		return Value{text: nested.Field, expr: nested}, nil
+	default:
+		return Value{}, fmt.Errorf("unexpected argument token %q", tok.Text)
+	}
+}
+
+func lookupKeywordHandler(name string) KeywordHandler {
+	if h, ok := keywordHandlers[name]; ok {
+		return h
+	}
+	return stockKeywordHandlers[name]
+}
+
+// evalExpr resolves expr's field and runs it through each stage's handler
+// in order.
+func evalExpr(expr *Expr, ctx *Context) (interface{}, error) {
+	ctx.Set("currentKey", expr.Field)
+	value := ResolveField(expr.Field, ctx.Data)
+
+	for _, stage := range expr.Stages {
+		if stage.Handler == nil {
+			continue
+		}
+		var err error
+		value, err = stage.Handler(value, ctx, stage.Args)
+		if err != nil {
+			return nil, fmt.Errorf("error applying keyword '%s': %v", stage.Name, err)
+		}
+	}
+	return value, nil
+}
+
+// EvalTemplate expands tmpl's literal and expression parts into a single
+// string, matching the old templateRegex.ReplaceAllStringFunc behavior: an
+// expression stage that errors renders as "Error: ...", and a nil result
+// renders as "".
+func EvalTemplate(tmpl *Template, ctx *Context) string {
+	builder := builderPool.Get().(*strings.Builder)
+	builder.Reset()
+	defer builderPool.Put(builder)
+
+	for _, part := range tmpl.Parts {
+		if part.Expr == nil {
+			builder.WriteString(part.Text)
+			continue
+		}
+		value, err := evalExpr(part.Expr, ctx)
+		if err != nil {
+			builder.WriteString(fmt.Sprintf("Error: %v", err))
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		builder.WriteString(fmt.Sprintf("%v", value))
+	}
+	return builder.String()
+}
+
+// --- keyword-argument helpers shared by the stock handlers ---
+
+// argResolved resolves the i'th argument, or returns nil if it wasn't
+// supplied.
+func argResolved(args []Value, i int, ctx *Context) (interface{}, error) {
+	if i >= len(args) {
+		return nil, nil
+	}
+	return args[i].Resolve(ctx)
+}
+
+// argString resolves the i'th argument as a string.
+func argString(args []Value, i int, ctx *Context) (string, error) {
+	v, err := argResolved(args, i, ctx)
+	if err != nil {
+		return "", err
+	}
+	if v == nil {
+		return "", nil
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprintf("%v", v), nil
+}
+
+// argInt resolves the i'th argument as an int, accepting the JSON-number
+// and string-digit forms a resolved value might take.
+func argInt(args []Value, i int, ctx *Context) (int, error) {
+	v, err := argResolved(args, i, ctx)
+	if err != nil {
+		return 0, err
+	}
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case float64:
+		return int(n), nil
+	case string:
+		return strconv.Atoi(n)
+	default:
+		return 0, fmt.Errorf("missing or non-numeric argument %d", i)
+	}
+}
+
+// parseRangeArgs parses an optional "(start,end)" argument pair, defaulting
+// to the entire string when no arguments were given.
+func parseRangeArgs(args []Value, ctx *Context, maxLen int) (int, int, error) {
+	if len(args) == 0 {
+		return 0, maxLen, nil
+	}
+	if len(args) != 2 {
+		return 0, 0, fmt.Errorf("expected 'start,end' arguments")
+	}
+	start, err := argInt(args, 0, ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid start index: %v", err)
+	}
+	end, err := argInt(args, 1, ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid end index: %v", err)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end > maxLen {
+		end = maxLen
+	}
+	if start > end {
+		return 0, 0, fmt.Errorf("start index %d after end index %d", start, end)
+	}
+	return start, end, nil
+}
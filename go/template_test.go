@@ -0,0 +1,20 @@
+package jtran
+
+import "testing"
+
+// TestRangeKeywordRejectsStartAfterEnd guards against the panic a
+// schema like "trim(10,2)" used to cause: parseRangeArgs must reject a
+// start index past the (clamped) end index instead of slicing with it.
+func TestRangeKeywordRejectsStartAfterEnd(t *testing.T) {
+	schema := map[string]interface{}{"out": "${name|trim(10,2)}"}
+	data := map[string]interface{}{"name": "ab"}
+
+	result, err := TransformData(schema, data)
+	if err != nil {
+		t.Fatalf("TransformData: %v", err)
+	}
+	s, ok := result["out"].(string)
+	if !ok || len(s) < len("Error:") || s[:len("Error:")] != "Error:" {
+		t.Fatalf("out = %v, want an \"Error: ...\" string", result["out"])
+	}
+}